@@ -15,12 +15,14 @@ func main() {
 	var (
 		input               []string
 		packageName, output string
+		plugins             []string
+		singleFile          bool
 	)
 	generateCmd := &cobra.Command{
 		Use:   "generate",
 		Short: "Generate go serializers/deserializers for Kafka messages",
 		Run: func(cmd *cobra.Command, args []string) {
-			err := generate.Run(&packageName, &input, &output)
+			err := generate.Run(&packageName, &input, &output, &plugins, &singleFile, version)
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
@@ -28,8 +30,10 @@ func main() {
 		},
 	}
 	generateCmd.Flags().StringVarP(&packageName, "package", "p", "", "The go package name to use in generated files")
-	generateCmd.Flags().StringArrayVarP(&input, "input", "i", []string{""}, "The input directory to use")
-	generateCmd.Flags().StringVarP(&output, "output", "o", "", "The output directory to create")
+	generateCmd.Flags().StringArrayVarP(&input, "input", "i", []string{""}, "The input directory to use, or - to read a single spec from stdin")
+	generateCmd.Flags().StringVarP(&output, "output", "o", "", "The output directory to create, or - to write to stdout")
+	generateCmd.Flags().StringSliceVar(&plugins, "plugin", []string{"core"}, "Comma-separated list of output plugins to run, e.g. core,rpc")
+	generateCmd.Flags().BoolVar(&singleFile, "single-file", false, "Coalesce all inputs into one generated .go file instead of one per message")
 	for _, f := range []string{"package", "input", "output"} {
 		err := generateCmd.MarkFlagRequired(f)
 		if err != nil {
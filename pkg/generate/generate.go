@@ -3,49 +3,141 @@ package generate
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/leizor/go-kafka-message-generator/pkg/model"
 	"github.com/leizor/go-kafka-message-generator/pkg/util"
+	"github.com/leizor/go-kafka-message-generator/pkg/validate"
 )
 
-func Run(packageName *string, in *[]string, out *string) error {
-	err := os.MkdirAll(*out, os.ModePerm)
-	if err != nil {
-		return err
+// stdioPath is the "-" sentinel that selects stdin for an input source, or stdout for the output destination,
+// instead of a path on disk.
+const stdioPath = "-"
+
+// generatorAPIVersion is the compatibility version baked into every file this package emits, via the
+// GoKafkaMessageGeneratorPackageIsVersionN guard. Bump it when a generated-code change would break files already
+// emitted by an older version of this generator.
+const generatorAPIVersion = 1
+
+// Run parses every message spec from the input sources and runs each of the named plugins over the full set,
+// writing whatever files they produce to the output destination. Plugins run in the order given, and each sees
+// every parsed spec, so a plugin like rpc can correlate a request message with its response even though they come
+// from separate files. An input of "-" reads a single spec from stdin instead of listing a directory; an output of
+// "-" streams every generated file to stdout, each preceded by a header comment naming it, instead of writing files
+// to disk. version is the CLI's own version string, embedded into generated files' "Code generated by" header.
+func Run(packageName *string, in *[]string, out *string, plugins *[]string, singleFile *bool, version string) error {
+	toStdout := *out == stdioPath
+	if !toStdout {
+		err := os.MkdirAll(*out, os.ModePerm)
+		if err != nil {
+			return err
+		}
+	}
+
+	var specs []model.Message
+	var validationErrs []error
+
+	parseSpec := func(source string, data []byte) {
+		spec := model.Message{}
+		if err := json.Unmarshal(skipCommentLines(data), &spec); err != nil {
+			validationErrs = append(validationErrs, fmt.Errorf("problem unmarshalling json in '%s': %w", source, err))
+			return
+		}
+		if err := validate.Validate(spec); err != nil {
+			validationErrs = append(validationErrs, fmt.Errorf("%s: %w", source, err))
+			return
+		}
+		specs = append(specs, spec)
 	}
 
 	for _, dir := range *in {
+		if dir == stdioPath {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("problem reading stdin: %w", err)
+			}
+			parseSpec("stdin", data)
+			continue
+		}
+
 		dirEntries, err := os.ReadDir(dir)
 		if err != nil {
 			return err
 		}
 		for _, entry := range dirEntries {
-			if !entry.IsDir() {
-				data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
-				if err != nil {
-					return fmt.Errorf("problem reading file '%s': %w", entry.Name(), err)
-				}
+			if entry.IsDir() {
+				continue
+			}
 
-				spec := model.Message{}
-				err = json.Unmarshal(skipCommentLines(data), &spec)
-				if err != nil {
-					return fmt.Errorf("problem unmarshalling json in '%s': %w", entry.Name(), err)
-				}
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return fmt.Errorf("problem reading file '%s': %w", entry.Name(), err)
+			}
+			parseSpec(entry.Name(), data)
+		}
+	}
+	if err := errors.Join(validationErrs...); err != nil {
+		return err
+	}
 
-				cb := util.NewCodeBuffer()
-				filename, err := generateFile(*packageName, spec, cb)
-				if err != nil {
-					return fmt.Errorf("problem generating file for '%s': %w", entry.Name(), err)
-				}
-				err = writeFile(filepath.Join(*out, filename), cb)
-				if err != nil {
-					return fmt.Errorf("problem writing file '%s': %w", filename, err)
+	registered, err := resolvePlugins(*plugins, pluginOptions{
+		packageName: *packageName,
+		version:     version,
+		singleFile:  *singleFile,
+	}, specs)
+	if err != nil {
+		return err
+	}
+
+	var stdout *bufio.Writer
+	if toStdout {
+		stdout = bufio.NewWriter(os.Stdout)
+		defer func() {
+			_ = stdout.Flush()
+		}()
+	}
+
+	writeGeneratedFile := func(file GeneratedFile) error {
+		if toStdout {
+			if _, err := fmt.Fprintf(stdout, "//go:build ignore\n// file: %s\n\n", file.Name); err != nil {
+				return err
+			}
+			if err := file.Buffer.Write(stdout); err != nil {
+				return err
+			}
+			_, err := stdout.WriteString("\n")
+			return err
+		}
+		return writeFile(filepath.Join(*out, file.Name), file.Buffer)
+	}
+
+	for _, p := range registered {
+		for _, spec := range specs {
+			file := &GeneratedFile{Buffer: util.NewCodeBuffer()}
+			err := p.Generate(file, &spec)
+			if err != nil {
+				return fmt.Errorf("problem running plugin '%s' for '%s': %w", p.Name(), spec.Name, err)
+			}
+			if file.Name == "" {
+				continue
+			}
+			if err := writeGeneratedFile(*file); err != nil {
+				return fmt.Errorf("problem writing file '%s': %w", file.Name, err)
+			}
+		}
+
+		if emitter, ok := p.(additionalFilesPlugin); ok {
+			for _, file := range emitter.AdditionalFiles() {
+				if err := writeGeneratedFile(file); err != nil {
+					return fmt.Errorf("problem writing file '%s': %w", file.Name, err)
 				}
 			}
 		}
@@ -76,7 +168,11 @@ func skipCommentLines(data []byte) []byte {
 	return []byte(sb.String())
 }
 
-func generateFile(packageName string, spec model.Message, cb util.CodeBuffer) (string, error) {
+// generateFile emits a complete .go file for spec: the "Code generated by" header, package declaration, import
+// block, and then whatever generateFileBody produces. The file compiles only alongside corePlugin's version.go, which
+// defines the version guard and uvarintSize helper it references.
+func generateFile(packageName string, spec model.Message, cb util.CodeBuffer, generatorVersion string) (string, error) {
+	cb.AddLine("// Code generated by kmg %s. DO NOT EDIT.", generatorVersion)
 	cb.AddLine("package %s", packageName)
 
 	cb.AddLine("import (")
@@ -87,23 +183,45 @@ func generateFile(packageName string, spec model.Message, cb util.CodeBuffer) (s
 	cb.DecrementIndent()
 	cb.AddLine(")")
 
+	if err := generateFileBody(cb, spec); err != nil {
+		return "", err
+	}
+
+	return spec.Name + ".go", nil
+}
+
+// generateFileBody emits everything generateFile does except the header, package declaration, and import block, so
+// single-file mode can combine many messages' bodies under one shared header and a deduped import block.
+func generateFileBody(cb util.CodeBuffer, spec model.Message) error {
+	flexible := spec.FlexibleVersions != ""
+
 	cb.AddLine("type %s struct {", spec.Name)
 	cb.IncrementIndent()
 	addStructFields(cb, spec.Name, spec.Fields)
 	cb.DecrementIndent()
 	cb.AddLine("}")
 
+	addAPIMetadata(cb, spec)
+
+	if flexible {
+		addIsFlexibleHelper(cb, spec.Name, spec.FlexibleVersions)
+		// addReadTaggedFieldsSection/addMarshalTaggedFieldsSection emit a tagged-field trailer for every flexible
+		// version regardless of whether this spec actually declares any tagged fields, so the carrier type they
+		// reference has to exist unconditionally too.
+		addTaggedFieldEntryType(cb, spec.Name)
+	}
+
 	for _, cs := range spec.CommonStructs {
-		err := addCommonStruct(cb, spec.Name, cs)
+		err := addCommonStruct(cb, spec.Name, cs, flexible)
 		if err != nil {
-			return "", fmt.Errorf("problem adding common struct: %w", err)
+			return fmt.Errorf("problem adding common struct: %w", err)
 		}
 	}
 
 	for _, inlineStruct := range collectInlineStructs(spec.Fields) {
-		err := addInlineStruct(cb, spec.Name, inlineStruct)
+		err := addInlineStruct(cb, spec.Name, inlineStruct, flexible)
 		if err != nil {
-			return "", fmt.Errorf("problem adding inline struct: %w", err)
+			return fmt.Errorf("problem adding inline struct: %w", err)
 		}
 	}
 
@@ -112,32 +230,71 @@ func generateFile(packageName string, spec model.Message, cb util.CodeBuffer) (s
 	cb.AddLine("var res %s", spec.Name)
 
 	for _, field := range spec.Fields {
-		err := addReadField(cb, spec.Name, field)
+		if field.Tag != nil {
+			continue
+		}
+		err := addReadField(cb, spec.Name, field, flexible)
 		if err != nil {
-			return "", err
+			return err
 		}
 	}
+	addReadTaggedFieldsSection(cb, spec.Name, spec.Fields, flexible)
 	cb.AddLine("return res, nil")
 	cb.DecrementIndent()
 	cb.AddLine("}")
 
-	// TODO: Support tagged fields
-	// https://cwiki.apache.org/confluence/display/KAFKA/KIP-482%3A+The+Kafka+Protocol+should+Support+Optional+Tagged+Fields
+	cb.AddLine("func (m *%s) Size(version int) int {", spec.Name)
+	cb.IncrementIndent()
+	cb.AddLine("n := 0")
+	for _, field := range spec.Fields {
+		if field.Tag != nil {
+			continue
+		}
+		addSizeField(cb, spec.Name, field, flexible)
+	}
+	addSizeTaggedFieldsSection(cb, spec.Name, spec.Fields, flexible)
+	cb.AddLine("return n")
+	cb.DecrementIndent()
+	cb.AddLine("}")
 
-	return spec.Name + ".go", nil
+	cb.AddLine("func (m *%s) Marshal(version int) ([]byte, error) {", spec.Name)
+	cb.IncrementIndent()
+	cb.AddLine("buf := make([]byte, 0, m.Size(version))")
+	for _, field := range spec.Fields {
+		if field.Tag != nil {
+			continue
+		}
+		err := addMarshalField(cb, spec.Name, field, flexible)
+		if err != nil {
+			return err
+		}
+	}
+	err := addMarshalTaggedFieldsSection(cb, spec.Name, spec.Fields, flexible)
+	if err != nil {
+		return err
+	}
+	cb.AddLine("return buf, nil")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+
+	return nil
 }
 
 // We depend on the following built-in packages, but only selectively depending on the data types used in each file:
-//   - bytes
 //   - encoding/binary
 //   - fmt
 func collectImports(spec model.Message) []string {
 	dependsOn := map[string]bool{
-		"bytes":           false,
 		"encoding/binary": true,
 		"fmt":             false,
 	}
 
+	if spec.FlexibleVersions != "" {
+		// isFlexible-gated reads always read a uvarint (tagged-field count, compact string/bytes lengths) via
+		// binary.Uvarint and may return an fmt.Errorf, regardless of which field types are declared.
+		dependsOn["fmt"] = true
+	}
+
 	fields := make([]model.MessageField, 0, len(spec.Fields)+len(spec.CommonStructs))
 	for _, f := range spec.Fields {
 		fields = append(fields, f)
@@ -149,14 +306,12 @@ func collectImports(spec model.Message) []string {
 	}
 
 	for _, field := range fields {
-		isArray, fieldType := deconstructFieldType(field.Type)
-		if isArray {
-			dependsOn["bytes"] = true
-			dependsOn["fmt"] = true
-		}
+		_, fieldType := deconstructFieldType(field.Type)
+		// A legacy array's length is a plain big-endian uint32, needing only encoding/binary; a flexible one's compact
+		// length is a uvarint read via binary.Uvarint with an fmt.Errorf, but that's already accounted for
+		// above since only a flexible spec can reach that branch.
 		switch fieldType {
 		case "bytes":
-			dependsOn["bytes"] = true
 			dependsOn["fmt"] = true
 		case "string":
 			dependsOn["fmt"] = true
@@ -177,6 +332,50 @@ func collectImports(spec model.Message) []string {
 	return imports
 }
 
+// addAPIMetadata emits the module-level metadata a dispatcher needs to route a message without reflection: its API
+// key (when it has one), its supported and flexible version ranges, the MessageType method, and an assertion
+// against the generator-compatibility guard that corePlugin's AdditionalFiles defines once per package (in
+// version.go, or in generated.go in single-file mode).
+func addAPIMetadata(cb util.CodeBuffer, spec model.Message) {
+	cb.AddLine("const _ = GoKafkaMessageGeneratorPackageIsVersion%d", generatorAPIVersion)
+
+	minVersion, maxVersion := parseVersionBounds(spec.ValidVersions)
+	cb.AddLine("const (")
+	cb.IncrementIndent()
+	if spec.ApiKey != nil {
+		cb.AddLine("%sAPIKey           = %d", spec.Name, *spec.ApiKey)
+	}
+	cb.AddLine("%sMinVersion       = %d", spec.Name, minVersion)
+	cb.AddLine("%sMaxVersion       = %d", spec.Name, maxVersion)
+	cb.AddLine("%sFlexibleVersions = %q", spec.Name, spec.FlexibleVersions)
+	cb.DecrementIndent()
+	cb.AddLine(")")
+
+	cb.AddLine("func (m *%s) MessageType() string {", spec.Name)
+	cb.IncrementIndent()
+	cb.AddLine("return %q", spec.MessageType)
+	cb.DecrementIndent()
+	cb.AddLine("}")
+}
+
+// parseVersionBounds turns a Versions string (e.g. "3+", "2-4", "5") into the inclusive [min, max] version range it
+// describes. An unbounded "N+" range reports max as -1, since the upper bound isn't known until a newer version of
+// the schema is added.
+func parseVersionBounds(versions string) (int, int) {
+	if m := versionRangeRegexp.FindStringSubmatch(versions); len(m) == 3 {
+		min, _ := strconv.Atoi(m[1])
+		max, _ := strconv.Atoi(m[2])
+		return min, max
+	} else if m := versionMinRegexp.FindStringSubmatch(versions); len(m) == 2 {
+		min, _ := strconv.Atoi(m[1])
+		return min, -1
+	} else if m := versionRegexp.FindStringSubmatch(versions); len(m) == 2 {
+		v, _ := strconv.Atoi(m[1])
+		return v, v
+	}
+	return 0, -1
+}
+
 func collectInlineStructs(fields []model.MessageField) (res []model.MessageField) {
 	for _, field := range fields {
 		if len(field.Fields) > 0 {
@@ -187,71 +386,281 @@ func collectInlineStructs(fields []model.MessageField) (res []model.MessageField
 	return res
 }
 
+// primitiveCodec describes the Go type, fixed wire width, and read/write emitters for one fixed-width primitive
+// type name. Keying the per-type logic off this table, rather than switching on the type name in each of
+// addReadFieldBody, addSizeFieldValue, and addMarshalFieldValue, means a future primitive (float64, bool, records)
+// needs only one new entry here.
+type primitiveCodec struct {
+	goType string
+	size   int
+	read   func(cb util.CodeBuffer, fieldName string, appendToArray bool)
+	write  func(cb util.CodeBuffer, expr string, bufVar string)
+}
+
+var primitiveCodecs = map[string]primitiveCodec{
+	"int8": {
+		goType: "int8",
+		size:   1,
+		read: func(cb util.CodeBuffer, fieldName string, appendToArray bool) {
+			if appendToArray {
+				cb.AddLine("res.%s = append(res.%s, int8(data[0]))", capitalize(fieldName), capitalize(fieldName))
+			} else {
+				cb.AddLine("res.%s = int8(data[0])", capitalize(fieldName))
+			}
+			cb.AddLine("data = data[1:]")
+		},
+		write: func(cb util.CodeBuffer, expr string, bufVar string) {
+			cb.AddLine("%s = append(%s, byte(%s))", bufVar, bufVar, expr)
+		},
+	},
+	"int16": {
+		goType: "int16",
+		size:   2,
+		read: func(cb util.CodeBuffer, fieldName string, appendToArray bool) {
+			if appendToArray {
+				cb.AddLine("res.%s = append(res.%s, int16(binary.BigEndian.Uint16(data)))", capitalize(fieldName), capitalize(fieldName))
+			} else {
+				cb.AddLine("res.%s = int16(binary.BigEndian.Uint16(data))", capitalize(fieldName))
+			}
+			cb.AddLine("data = data[2:]")
+		},
+		write: func(cb util.CodeBuffer, expr string, bufVar string) {
+			cb.AddLine("%s = binary.BigEndian.AppendUint16(%s, uint16(%s))", bufVar, bufVar, expr)
+		},
+	},
+	"int32": {
+		goType: "int32",
+		size:   4,
+		read: func(cb util.CodeBuffer, fieldName string, appendToArray bool) {
+			if appendToArray {
+				cb.AddLine("res.%s = append(res.%s, int32(binary.BigEndian.Uint32(data)))", capitalize(fieldName), capitalize(fieldName))
+			} else {
+				cb.AddLine("res.%s = int32(binary.BigEndian.Uint32(data))", capitalize(fieldName))
+			}
+			cb.AddLine("data = data[4:]")
+		},
+		write: func(cb util.CodeBuffer, expr string, bufVar string) {
+			cb.AddLine("%s = binary.BigEndian.AppendUint32(%s, uint32(%s))", bufVar, bufVar, expr)
+		},
+	},
+	"int64": {
+		goType: "int64",
+		size:   8,
+		read: func(cb util.CodeBuffer, fieldName string, appendToArray bool) {
+			if appendToArray {
+				cb.AddLine("res.%s = append(res.%s, int64(binary.BigEndian.Uint64(data)))", capitalize(fieldName), capitalize(fieldName))
+			} else {
+				cb.AddLine("res.%s = int64(binary.BigEndian.Uint64(data))", capitalize(fieldName))
+			}
+			cb.AddLine("data = data[8:]")
+		},
+		write: func(cb util.CodeBuffer, expr string, bufVar string) {
+			cb.AddLine("%s = binary.BigEndian.AppendUint64(%s, uint64(%s))", bufVar, bufVar, expr)
+		},
+	},
+	// uuid is carried on the wire as 16 raw bytes (not a length-prefixed type), so it decodes to a fixed-size byte
+	// array rather than one of Go's built-in integer types.
+	"uuid": {
+		goType: "[16]byte",
+		size:   16,
+		read: func(cb util.CodeBuffer, fieldName string, appendToArray bool) {
+			cb.AddLine("{")
+			cb.IncrementIndent()
+			cb.AddLine("var v [16]byte")
+			cb.AddLine("copy(v[:], data[0:16])")
+			if appendToArray {
+				cb.AddLine("res.%s = append(res.%s, v)", capitalize(fieldName), capitalize(fieldName))
+			} else {
+				cb.AddLine("res.%s = v", capitalize(fieldName))
+			}
+			cb.DecrementIndent()
+			cb.AddLine("}")
+			cb.AddLine("data = data[16:]")
+		},
+		write: func(cb util.CodeBuffer, expr string, bufVar string) {
+			cb.AddLine("%s = append(%s, %s[:]...)", bufVar, bufVar, expr)
+		},
+	},
+}
+
 func addStructFields(cb util.CodeBuffer, name string, fields []model.MessageField) {
 	for _, field := range fields {
-		fieldType := field.Type
-		switch fieldType {
-		case "uuid":
-			fieldType = "uint16"
-		case "bytes":
-			fieldType = "[]byte"
-		}
 		if len(field.Fields) > 0 {
-			// This is an inline struct.
-			isArray, ft := deconstructFieldType(fieldType)
+			// This is an inline struct; its Go type is named after the enclosing message plus the field's own
+			// (possibly array) type suffix.
+			isArray, ft := deconstructFieldType(field.Type)
 			if isArray {
 				cb.AddLine("%s []%s%s", capitalize(field.Name), name, ft)
 			} else {
-				cb.AddLine("%s %s%s", capitalize(field.Name), name, fieldType)
+				cb.AddLine("%s %s%s", capitalize(field.Name), name, ft)
 			}
-		} else {
-			cb.AddLine("%s %s", capitalize(field.Name), fieldType)
+			continue
+		}
+
+		isArray, baseType := deconstructFieldType(field.Type)
+		goType := baseType
+		if codec, ok := primitiveCodecs[baseType]; ok {
+			goType = codec.goType
+		} else if baseType == "bytes" {
+			goType = "[]byte"
 		}
+		if isArray {
+			goType = "[]" + goType
+		}
+		cb.AddLine("%s %s", capitalize(field.Name), goType)
 	}
 }
 
-func addCommonStruct(cb util.CodeBuffer, name string, cs model.CommonStruct) error {
-	versions := addVersionIfClause(cb, cs.Versions)
-	if versions {
-		cb.IncrementIndent()
+// addUvarintSizeHelper emits a small helper that reports how many bytes binary.AppendUvarint would use to encode x,
+// so Size can precompute a Marshal buffer's capacity without encoding the value twice. It's invariant across every
+// message in a package, so corePlugin emits it once via AdditionalFiles rather than once per generated file.
+func addUvarintSizeHelper(cb util.CodeBuffer) {
+	cb.AddLine("func uvarintSize(x uint64) int {")
+	cb.IncrementIndent()
+	cb.AddLine("n := 1")
+	cb.AddLine("for x >= 0x80 {")
+	cb.IncrementIndent()
+	cb.AddLine("x >>= 7")
+	cb.AddLine("n++")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+	cb.AddLine("return n")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+}
+
+// isFlexibleFuncName returns the name of the per-message isFlexible helper addIsFlexibleHelper emits for name,
+// prefixed the same way MinVersion/MaxVersion/etc. are in addAPIMetadata so that two flexible messages generated
+// into the same package never collide.
+func isFlexibleFuncName(name string) string {
+	return name + "IsFlexible"
+}
+
+// addIsFlexibleHelper emits a per-message isFlexible helper derived from the message's flexibleVersions, so every
+// read/write path can decide at runtime whether the version being processed uses the KIP-482 compact encodings
+// (varint-prefixed strings/bytes/arrays, trailing tagged fields) or the legacy fixed-width ones.
+func addIsFlexibleHelper(cb util.CodeBuffer, name string, flexibleVersions string) {
+	cb.AddLine("func %s(version int) bool {", isFlexibleFuncName(name))
+	cb.IncrementIndent()
+	cb.AddLine("return %s", flexibleVersionCondition(flexibleVersions))
+	cb.DecrementIndent()
+	cb.AddLine("}")
+}
+
+// flexibleVersionCondition turns a flexibleVersions string (e.g. "3+", "2-4", "5") into the Go boolean expression
+// that decides whether a given `version` falls inside it.
+func flexibleVersionCondition(versions string) string {
+	if m := versionRangeRegexp.FindStringSubmatch(versions); len(m) == 3 {
+		return fmt.Sprintf("version >= %s && version <= %s", m[1], m[2])
+	} else if m := versionMinRegexp.FindStringSubmatch(versions); len(m) == 2 {
+		return fmt.Sprintf("version >= %s", m[1])
+	} else if m := versionRegexp.FindStringSubmatch(versions); len(m) == 2 {
+		return fmt.Sprintf("version == %s", m[1])
 	}
+	return "false"
+}
+
+// taggedFieldEntryTypeName returns the name of the per-message taggedFieldEntry type addTaggedFieldEntryType emits
+// for name, prefixed for the same reason isFlexibleFuncName is.
+func taggedFieldEntryTypeName(name string) string {
+	return name + "TaggedFieldEntry"
+}
 
+// addTaggedFieldEntryType emits the small carrier type Marshal uses to build up a flexible version's tagged-field
+// trailer before it knows the final count.
+func addTaggedFieldEntryType(cb util.CodeBuffer, name string) {
+	cb.AddLine("type %s struct {", taggedFieldEntryTypeName(name))
+	cb.IncrementIndent()
+	cb.AddLine("tag     uint64")
+	cb.AddLine("payload []byte")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+}
+
+// collectTaggedFields returns the KIP-482 tagged fields among fields, ordered by ascending tag.
+func collectTaggedFields(fields []model.MessageField) []model.MessageField {
+	var res []model.MessageField
+	for _, f := range fields {
+		if f.Tag != nil {
+			res = append(res, f)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return *res[i].Tag < *res[j].Tag })
+	return res
+}
+
+// addCommonStruct emits a CommonStruct's type and Read/Size/Marshal methods. cs.Versions isn't used to gate these
+// declarations: Go doesn't allow a type or func declaration to be conditional on a runtime value, and the struct
+// type itself needs to exist regardless of version, since it's referenced by field declarations (addStructFields)
+// across every version of the enclosing message. A field's own Versions already controls when that field, and so
+// the common struct it refers to, is actually read/sized/marshalled.
+func addCommonStruct(cb util.CodeBuffer, name string, cs model.CommonStruct, flexible bool) error {
 	cb.AddLine("type %s struct {", cs.Name)
 	cb.IncrementIndent()
 	addStructFields(cb, name, cs.Fields)
 	cb.DecrementIndent()
 	cb.AddLine("}")
 
-	cb.AddLine("func New%s(data []byte) (%s, error) {", capitalize(cs.Name), capitalize(cs.Name))
+	cb.AddLine("func New%s(data []byte, version int) (%s, error) {", capitalize(cs.Name), capitalize(cs.Name))
 	cb.IncrementIndent()
 	cb.AddLine("var res %s", capitalize(cs.Name))
 	for _, field := range cs.Fields {
-		err := addReadField(cb, name, field)
+		if field.Tag != nil {
+			continue
+		}
+		err := addReadField(cb, name, field, flexible)
 		if err != nil {
 			return err
 		}
 	}
+	addReadTaggedFieldsSection(cb, name, cs.Fields, flexible)
 	cb.AddLine("return res, nil")
 	cb.DecrementIndent()
 	cb.AddLine("}")
 
-	if versions {
-		cb.DecrementIndent()
-		cb.AddLine("}")
+	cb.AddLine("func (m *%s) Size(version int) int {", capitalize(cs.Name))
+	cb.IncrementIndent()
+	cb.AddLine("n := 0")
+	for _, field := range cs.Fields {
+		if field.Tag != nil {
+			continue
+		}
+		addSizeField(cb, name, field, flexible)
+	}
+	addSizeTaggedFieldsSection(cb, name, cs.Fields, flexible)
+	cb.AddLine("return n")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+
+	cb.AddLine("func (m *%s) Marshal(version int) ([]byte, error) {", capitalize(cs.Name))
+	cb.IncrementIndent()
+	cb.AddLine("buf := make([]byte, 0, m.Size(version))")
+	for _, field := range cs.Fields {
+		if field.Tag != nil {
+			continue
+		}
+		err := addMarshalField(cb, name, field, flexible)
+		if err != nil {
+			return err
+		}
+	}
+	err := addMarshalTaggedFieldsSection(cb, name, cs.Fields, flexible)
+	if err != nil {
+		return err
 	}
+	cb.AddLine("return buf, nil")
+	cb.DecrementIndent()
+	cb.AddLine("}")
 
 	return nil
 }
 
 // addInlineStruct adds inline structs to the code buffer. An inline struct is like a common struct but is not accessed
 // by other readers in the package. In practice, the only difference is that we prepend the message name to the struct
-// name.
-func addInlineStruct(cb util.CodeBuffer, name string, field model.MessageField) error {
-	versions := addVersionIfClause(cb, field.Versions)
-	if versions {
-		cb.IncrementIndent()
-	}
-
+// name. field.Versions isn't used to gate these declarations, for the same reason addCommonStruct doesn't gate on
+// cs.Versions: the type and its methods must exist unconditionally, since the field's own Versions check already
+// controls when they're used.
+func addInlineStruct(cb util.CodeBuffer, name string, field model.MessageField, flexible bool) error {
 	_, fieldType := deconstructFieldType(field.Type)
 	cb.AddLine("type %s%s struct {", name, fieldType)
 	cb.IncrementIndent()
@@ -259,135 +668,531 @@ func addInlineStruct(cb util.CodeBuffer, name string, field model.MessageField)
 	cb.DecrementIndent()
 	cb.AddLine("}")
 
-	cb.AddLine("func New%s%s(data []byte) (%s%s, error) {", name, capitalize(fieldType), name, capitalize(fieldType))
+	cb.AddLine("func New%s%s(data []byte, version int) (%s%s, error) {", name, capitalize(fieldType), name, capitalize(fieldType))
 	cb.IncrementIndent()
 	cb.AddLine("var res %s%s", name, capitalize(fieldType))
 	for _, f := range field.Fields {
-		err := addReadField(cb, name, f)
+		if f.Tag != nil {
+			continue
+		}
+		err := addReadField(cb, name, f, flexible)
 		if err != nil {
 			return err
 		}
 	}
+	addReadTaggedFieldsSection(cb, name, field.Fields, flexible)
 	cb.AddLine("return res, nil")
 	cb.DecrementIndent()
 	cb.AddLine("}")
 
-	if versions {
-		cb.DecrementIndent()
-		cb.AddLine("}")
+	cb.AddLine("func (m *%s%s) Size(version int) int {", name, capitalize(fieldType))
+	cb.IncrementIndent()
+	cb.AddLine("n := 0")
+	for _, f := range field.Fields {
+		if f.Tag != nil {
+			continue
+		}
+		addSizeField(cb, name, f, flexible)
+	}
+	addSizeTaggedFieldsSection(cb, name, field.Fields, flexible)
+	cb.AddLine("return n")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+
+	cb.AddLine("func (m *%s%s) Marshal(version int) ([]byte, error) {", name, capitalize(fieldType))
+	cb.IncrementIndent()
+	cb.AddLine("buf := make([]byte, 0, m.Size(version))")
+	for _, f := range field.Fields {
+		if f.Tag != nil {
+			continue
+		}
+		err := addMarshalField(cb, name, f, flexible)
+		if err != nil {
+			return err
+		}
+	}
+	err := addMarshalTaggedFieldsSection(cb, name, field.Fields, flexible)
+	if err != nil {
+		return err
 	}
+	cb.AddLine("return buf, nil")
+	cb.DecrementIndent()
+	cb.AddLine("}")
 
 	return nil
 }
 
-func addReadField(cb util.CodeBuffer, name string, field model.MessageField) error {
+func addReadField(cb util.CodeBuffer, name string, field model.MessageField, flexible bool) error {
 	versions := addVersionIfClause(cb, field.Versions)
 	if versions {
 		cb.IncrementIndent()
 	}
 
+	addReadFieldBody(cb, name, field, flexible)
+
+	if versions {
+		cb.DecrementIndent()
+		if field.Default != nil {
+			cb.AddLine("} else {")
+			cb.IncrementIndent()
+			switch field.Type {
+			case "string":
+				defaultString, ok := (*field.Default).(string)
+				if !ok {
+					return fmt.Errorf("unexpected value type for string default: %v (%T)", *field.Default, *field.Default)
+				}
+				cb.AddLine("res.%s = %s", capitalize(field.Name), defaultString)
+			case "bytes":
+				defaultBytes, ok := (*field.Default).(string)
+				if !ok {
+					return fmt.Errorf("unexpected value type for bytes default: %v (%T)", *field.Default, *field.Default)
+				}
+				cb.AddLine("res.%s = []byte(%q)", capitalize(field.Name), defaultBytes)
+			case "int8", "int16", "int32", "int64":
+				defaultNum, ok := (*field.Default).(float64)
+				if !ok {
+					return fmt.Errorf("unexpected value type for int default: %v (%T)", *field.Default, *field.Default)
+				}
+				cb.AddLine("res.%s = %d", capitalize(field.Name), int64(defaultNum))
+			default:
+				return fmt.Errorf("unrecognized field type: %s", field.Type)
+			}
+			cb.DecrementIndent()
+		}
+		cb.AddLine("}")
+	}
+
+	return nil
+}
+
+// addReadFieldBody emits the decode logic for a single field, without the surrounding version-if clause that
+// addReadField adds. It is reused as-is by the tagged-field dispatch, where the field is only ever present because
+// its tag was seen, so no extra version check is needed.
+func addReadFieldBody(cb util.CodeBuffer, name string, field model.MessageField, flexible bool) {
 	isArray, fieldType := deconstructFieldType(field.Type)
 	if isArray {
 		cb.AddLine("{")
 		cb.IncrementIndent()
-		cb.AddLine("arrLen, err := binary.ReadUvarint(bytes.NewReader(data))")
-		cb.AddLine("if err != nil {")
+		cb.AddLine("var arrLen uint64")
+		if flexible {
+			cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
+			cb.IncrementIndent()
+			addReadCompactArrayLength(cb)
+			cb.DecrementIndent()
+			cb.AddLine("} else {")
+			cb.IncrementIndent()
+			addReadLegacyArrayLength(cb)
+			cb.DecrementIndent()
+			cb.AddLine("}")
+		} else {
+			addReadLegacyArrayLength(cb)
+		}
+		cb.AddLine("for i := uint64(0); i < arrLen; i++ {")
 		cb.IncrementIndent()
-		cb.AddLine("return res, fmt.Errorf(\"problem reading uvarint: %%w\", err)")
+		if codec, ok := primitiveCodecs[fieldType]; ok {
+			codec.read(cb, field.Name, true)
+		} else {
+			switch fieldType {
+			case "string":
+				addReadString(cb, name, field.Name, true, flexible)
+			case "bytes":
+				addReadBytes(cb, name, field.Name, true, flexible)
+			default:
+				cb.AddLine("{")
+				cb.IncrementIndent()
+				if len(field.Fields) > 0 {
+					addAllocateInlineStruct(cb, name, field.Type)
+				} else {
+					addAllocateCommonStruct(cb, field.Type)
+				}
+				cb.AddLine("res.%s = append(res.%s, v)", capitalize(field.Name), capitalize(field.Name))
+				cb.DecrementIndent()
+				cb.AddLine("}")
+			}
+		}
 		cb.DecrementIndent()
 		cb.AddLine("}")
-		cb.AddLine("arrLen--")
-		cb.AddLine("for i := uint64(0); i < arrLen; i++ {")
-		cb.IncrementIndent()
-		switch fieldType {
-		case "int8":
-			cb.AddLine("res.%s = append(res.%s, int8(data[0]))", capitalize(field.Name), capitalize(field.Name))
-			cb.AddLine("data = data[1:]")
-		case "int16":
-			cb.AddLine("res.%s = append(res.%s, int16(binary.BigEndian.Uint16(data)))", capitalize(field.Name), capitalize(field.Name))
-		case "int32":
-			cb.AddLine("res.%s = append(res.%s, int32(binary.BigEndian.Uint32(data)))", capitalize(field.Name), capitalize(field.Name))
-		case "int64":
-			cb.AddLine("res.%s = append(res.%s, int32(binary.BigEndian.Uint64(data)))", capitalize(field.Name), capitalize(field.Name))
-		case "string":
-			addReadString(cb, field.Name, true)
-		case "uuid":
-			cb.AddLine("res.%s = append(res.%s, binary.BigEndian.Uint16(data))", capitalize(field.Name), capitalize(field.Name))
-		case "bytes":
-			addReadBytes(cb, field.Name, true)
-		default:
-			cb.AddLine("{")
-			cb.IncrementIndent()
-			if len(field.Fields) > 0 {
-				addAllocateInlineStruct(cb, name, field.Type)
-			} else {
-				addAllocateCommonStruct(cb, field.Type)
+		cb.DecrementIndent()
+		cb.AddLine("}")
+	} else {
+		if codec, ok := primitiveCodecs[fieldType]; ok {
+			codec.read(cb, field.Name, false)
+		} else {
+			switch fieldType {
+			case "string":
+				addReadString(cb, name, field.Name, false, flexible)
+			case "bytes":
+				addReadBytes(cb, name, field.Name, false, flexible)
+			default:
+				cb.AddLine("{")
+				cb.IncrementIndent()
+				if len(field.Fields) > 0 {
+					addAllocateInlineStruct(cb, name, field.Type)
+				} else {
+					addAllocateCommonStruct(cb, field.Type)
+				}
+				cb.AddLine("res.%s = v", capitalize(field.Name))
+				cb.DecrementIndent()
+				cb.AddLine("}")
 			}
-			cb.AddLine("res.%s = append(res.%s, v)", capitalize(field.Name), capitalize(field.Name))
+		}
+	}
+}
+
+// addReadUvarint emits a read of a single unsigned varint into varName (or discards it via "_"), then advances
+// data past however many bytes the varint itself occupied on the wire. Decoding the value isn't enough on its
+// own: every caller here goes on to use that value to slice data again (a string/bytes/array length, a tagged
+// field's tag or size), and data has to be past the varint's own encoding first or that slice reads from the
+// wrong offset.
+func addReadUvarint(cb util.CodeBuffer, varName string) {
+	cb.AddLine("%s, n := binary.Uvarint(data)", varName)
+	cb.AddLine("if n <= 0 {")
+	cb.IncrementIndent()
+	cb.AddLine("return res, fmt.Errorf(\"problem reading uvarint\")")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+	cb.AddLine("data = data[n:]")
+}
+
+// addReadLegacyArrayLength reads a legacy (non-flexible) array's length into arrLen as a big-endian int32 count,
+// the fixed-width counterpart to addReadCompactArrayLength.
+func addReadLegacyArrayLength(cb util.CodeBuffer) {
+	cb.AddLine("arrLen = uint64(binary.BigEndian.Uint32(data))")
+	cb.AddLine("data = data[4:]")
+}
+
+// addReadCompactArrayLength reads a KIP-482 compact array length into arrLen: an unsigned varint of N+1, where 0
+// means null (decoded here as a zero-length array, for the same reason addReadCompactString does).
+func addReadCompactArrayLength(cb util.CodeBuffer) {
+	addReadUvarint(cb, "compactArrLen")
+	cb.AddLine("if compactArrLen > 0 {")
+	cb.IncrementIndent()
+	cb.AddLine("arrLen = compactArrLen - 1")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+}
+
+// addReadTaggedFieldsSection emits the KIP-482 tagged-field trailer read for a flexible version: a varint count
+// followed by that many (tag, size, payload) triples. Known tags are decoded via addReadFieldBody against the
+// triple's payload; unrecognized tags are skipped by advancing past their encoded size.
+func addReadTaggedFieldsSection(cb util.CodeBuffer, name string, fields []model.MessageField, flexible bool) {
+	if !flexible {
+		return
+	}
+	tagged := collectTaggedFields(fields)
+
+	cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
+	cb.IncrementIndent()
+	addReadUvarint(cb, "numTaggedFields")
+	cb.AddLine("for i := uint64(0); i < numTaggedFields; i++ {")
+	cb.IncrementIndent()
+	// tag is only read into a name when some tag is actually known here; a spec with no tagged fields at all still
+	// reaches this loop for any unknown tag another version of the message might carry, but has nothing to switch
+	// on, so naming it would leave it unused.
+	if len(tagged) > 0 {
+		addReadUvarint(cb, "tag")
+	} else {
+		addReadUvarint(cb, "_")
+	}
+	addReadUvarint(cb, "size")
+	if len(tagged) > 0 {
+		cb.AddLine("payload := data[0:size]")
+		cb.AddLine("switch tag {")
+		for _, f := range tagged {
+			cb.AddLine("case %d:", *f.Tag)
+			cb.IncrementIndent()
+			cb.AddLine("data := payload")
+			addReadFieldBody(cb, name, f, flexible)
 			cb.DecrementIndent()
-			cb.AddLine("}")
 		}
-		cb.DecrementIndent()
 		cb.AddLine("}")
+	}
+	cb.AddLine("data = data[size:]")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+}
+
+// addSizeField emits the portion of a generated Size method that accounts for a single field, honoring the same
+// version gating that addReadField uses so Size(version) and Read agree on what is present on the wire.
+func addSizeField(cb util.CodeBuffer, name string, field model.MessageField, flexible bool) {
+	versions := addVersionIfClause(cb, field.Versions)
+	if versions {
+		cb.IncrementIndent()
+	}
+
+	addSizeFieldBody(cb, name, field, flexible, "n")
+
+	if versions {
 		cb.DecrementIndent()
 		cb.AddLine("}")
+	}
+}
+
+func addSizeFieldBody(cb util.CodeBuffer, name string, field model.MessageField, flexible bool, nVar string) {
+	isArray, fieldType := deconstructFieldType(field.Type)
+	if isArray {
+		if flexible {
+			cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
+			cb.IncrementIndent()
+			cb.AddLine("%s += uvarintSize(uint64(len(m.%s) + 1))", nVar, capitalize(field.Name))
+			cb.DecrementIndent()
+			cb.AddLine("} else {")
+			cb.IncrementIndent()
+			cb.AddLine("%s += 4", nVar)
+			cb.DecrementIndent()
+			cb.AddLine("}")
+		} else {
+			cb.AddLine("%s += 4", nVar)
+		}
+		if codec, ok := primitiveCodecs[fieldType]; ok {
+			// A fixed-width element contributes the same size regardless of its value, so sizing the whole array
+			// is a multiplication rather than a loop over elements that would otherwise go unused.
+			cb.AddLine("%s += len(m.%s) * %d", nVar, capitalize(field.Name), codec.size)
+		} else {
+			cb.AddLine("for _, v := range m.%s {", capitalize(field.Name))
+			cb.IncrementIndent()
+			addSizeFieldValue(cb, name, fieldType, "v", flexible, nVar)
+			cb.DecrementIndent()
+			cb.AddLine("}")
+		}
 	} else {
-		switch fieldType {
-		case "int8":
-			cb.AddLine("res.%s = int8(data[0])", capitalize(field.Name))
-			cb.AddLine("data = data[1:]")
-		case "int16":
-			cb.AddLine("res.%s = int16(binary.BigEndian.Uint16(data))", capitalize(field.Name))
-		case "int32":
-			cb.AddLine("res.%s = int32(binary.BigEndian.Uint32(data))", capitalize(field.Name))
-		case "int64":
-			cb.AddLine("res.%s = int64(binary.BigEndian.Uint64(data))", capitalize(field.Name))
-		case "string":
-			addReadString(cb, field.Name, false)
-		case "uuid":
-			cb.AddLine("res.%s = binary.BigEndian.Uint16(data)", capitalize(field.Name))
-		case "bytes":
-			addReadBytes(cb, field.Name, false)
-		default:
-			cb.AddLine("{")
+		addSizeFieldValue(cb, name, fieldType, fmt.Sprintf("m.%s", capitalize(field.Name)), flexible, nVar)
+	}
+}
+
+func addSizeFieldValue(cb util.CodeBuffer, name string, fieldType string, expr string, flexible bool, nVar string) {
+	if codec, ok := primitiveCodecs[fieldType]; ok {
+		cb.AddLine("%s += %d", nVar, codec.size)
+		return
+	}
+
+	switch fieldType {
+	case "string":
+		if flexible {
+			cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
 			cb.IncrementIndent()
-			if len(field.Fields) > 0 {
-				addAllocateInlineStruct(cb, name, field.Type)
-			} else {
-				addAllocateCommonStruct(cb, field.Type)
-			}
-			cb.AddLine("res.%s = v", capitalize(field.Name))
+			cb.AddLine("%s += uvarintSize(uint64(len(%s) + 1)) + len(%s)", nVar, expr, expr)
+			cb.DecrementIndent()
+			cb.AddLine("} else {")
+			cb.IncrementIndent()
+			cb.AddLine("%s += 2 + len(%s)", nVar, expr)
+			cb.DecrementIndent()
+			cb.AddLine("}")
+		} else {
+			cb.AddLine("%s += 2 + len(%s)", nVar, expr)
+		}
+	case "bytes":
+		if flexible {
+			cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
+			cb.IncrementIndent()
+			cb.AddLine("%s += uvarintSize(uint64(len(%s) + 1)) + len(%s)", nVar, expr, expr)
+			cb.DecrementIndent()
+			cb.AddLine("} else {")
+			cb.IncrementIndent()
+			cb.AddLine("%s += uvarintSize(uint64(len(%s))) + len(%s)", nVar, expr, expr)
 			cb.DecrementIndent()
 			cb.AddLine("}")
+		} else {
+			cb.AddLine("%s += uvarintSize(uint64(len(%s))) + len(%s)", nVar, expr, expr)
 		}
+	default:
+		cb.AddLine("%s += %s.Size(version)", nVar, expr)
+	}
+}
+
+// addSizeTaggedFieldsSection mirrors addReadTaggedFieldsSection for Size: it counts every tagged field whose
+// taggedVersions include the given version and adds their (tag, size, payload) encoding to n. Whether a field holds
+// a zero value is not checked, so an unset tagged field is counted (and marshalled) like a set one.
+func addSizeTaggedFieldsSection(cb util.CodeBuffer, name string, fields []model.MessageField, flexible bool) {
+	if !flexible {
+		return
+	}
+	tagged := collectTaggedFields(fields)
+
+	cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
+	cb.IncrementIndent()
+	cb.AddLine("numTagged := 0")
+	for _, f := range tagged {
+		versions := addVersionIfClause(cb, f.TaggedVersions)
+		if versions {
+			cb.IncrementIndent()
+		}
+		cb.AddLine("numTagged++")
+		cb.AddLine("{")
+		cb.IncrementIndent()
+		cb.AddLine("tagN := 0")
+		addSizeFieldBody(cb, name, f, flexible, "tagN")
+		cb.AddLine("n += uvarintSize(%d) + uvarintSize(uint64(tagN)) + tagN", *f.Tag)
+		cb.DecrementIndent()
+		cb.AddLine("}")
+		if versions {
+			cb.DecrementIndent()
+			cb.AddLine("}")
+		}
+	}
+	cb.AddLine("n += uvarintSize(uint64(numTagged))")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+}
+
+// addMarshalField emits the portion of a generated Marshal method that encodes a single field, mirroring the
+// decoding addReadField performs (including the version gating from addVersionIfClause) so Marshal output can be
+// round-tripped by Read.
+func addMarshalField(cb util.CodeBuffer, name string, field model.MessageField, flexible bool) error {
+	versions := addVersionIfClause(cb, field.Versions)
+	if versions {
+		cb.IncrementIndent()
+	}
+
+	err := addMarshalFieldBody(cb, name, field, flexible, "buf")
+	if err != nil {
+		return err
 	}
 
 	if versions {
 		cb.DecrementIndent()
-		if field.Default != nil {
+		cb.AddLine("}")
+	}
+
+	return nil
+}
+
+// addMarshalFieldBody emits the encode logic for a single field into bufVar, without the surrounding version-if
+// clause that addMarshalField adds. It is reused as-is to build each tagged field's payload into its own buffer
+// before that payload's length is known.
+func addMarshalFieldBody(cb util.CodeBuffer, name string, field model.MessageField, flexible bool, bufVar string) error {
+	isArray, fieldType := deconstructFieldType(field.Type)
+	if isArray {
+		if flexible {
+			cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
+			cb.IncrementIndent()
+			cb.AddLine("%s = binary.AppendUvarint(%s, uint64(len(m.%s) + 1))", bufVar, bufVar, capitalize(field.Name))
+			cb.DecrementIndent()
 			cb.AddLine("} else {")
 			cb.IncrementIndent()
-			switch field.Type {
-			case "string":
-				defaultString, ok := (*field.Default).(string)
-				if !ok {
-					return fmt.Errorf("unexpected value type for string default: %v (%T)", *field.Default, *field.Default)
-				}
-				cb.AddLine("res.%s = %s", capitalize(field.Name), defaultString)
-			case "int32", "int64":
-				defaultInt, ok := (*field.Default).(int)
-				if !ok {
-					return fmt.Errorf("unexpected value type for int default: %v (%T)", *field.Default, *field.Default)
-				}
-				cb.AddLine("res.%s = %d", capitalize(field.Name), defaultInt)
-			default:
-				return fmt.Errorf("unrecognized field type: %s", field.Type)
-			}
+			cb.AddLine("%s = binary.BigEndian.AppendUint32(%s, uint32(len(m.%s)))", bufVar, bufVar, capitalize(field.Name))
 			cb.DecrementIndent()
+			cb.AddLine("}")
+		} else {
+			cb.AddLine("%s = binary.BigEndian.AppendUint32(%s, uint32(len(m.%s)))", bufVar, bufVar, capitalize(field.Name))
 		}
+		cb.AddLine("for _, v := range m.%s {", capitalize(field.Name))
+		cb.IncrementIndent()
+		err := addMarshalFieldValue(cb, name, fieldType, "v", field.Name, flexible, bufVar)
+		if err != nil {
+			return err
+		}
+		cb.DecrementIndent()
 		cb.AddLine("}")
+	} else {
+		err := addMarshalFieldValue(cb, name, fieldType, fmt.Sprintf("m.%s", capitalize(field.Name)), field.Name, flexible, bufVar)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addMarshalFieldValue(cb util.CodeBuffer, name string, fieldType string, expr string, fieldName string, flexible bool, bufVar string) error {
+	if codec, ok := primitiveCodecs[fieldType]; ok {
+		codec.write(cb, expr, bufVar)
+		return nil
 	}
 
+	switch fieldType {
+	case "string":
+		if flexible {
+			cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
+			cb.IncrementIndent()
+			cb.AddLine("%s = binary.AppendUvarint(%s, uint64(len(%s) + 1))", bufVar, bufVar, expr)
+			cb.DecrementIndent()
+			cb.AddLine("} else {")
+			cb.IncrementIndent()
+			cb.AddLine("%s = binary.BigEndian.AppendUint16(%s, uint16(len(%s)))", bufVar, bufVar, expr)
+			cb.DecrementIndent()
+			cb.AddLine("}")
+		} else {
+			cb.AddLine("%s = binary.BigEndian.AppendUint16(%s, uint16(len(%s)))", bufVar, bufVar, expr)
+		}
+		cb.AddLine("%s = append(%s, %s...)", bufVar, bufVar, expr)
+	case "bytes":
+		if flexible {
+			cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
+			cb.IncrementIndent()
+			cb.AddLine("%s = binary.AppendUvarint(%s, uint64(len(%s) + 1))", bufVar, bufVar, expr)
+			cb.DecrementIndent()
+			cb.AddLine("} else {")
+			cb.IncrementIndent()
+			cb.AddLine("%s = binary.AppendUvarint(%s, uint64(len(%s)))", bufVar, bufVar, expr)
+			cb.DecrementIndent()
+			cb.AddLine("}")
+		} else {
+			cb.AddLine("%s = binary.AppendUvarint(%s, uint64(len(%s)))", bufVar, bufVar, expr)
+		}
+		cb.AddLine("%s = append(%s, %s...)", bufVar, bufVar, expr)
+	default:
+		cb.AddLine("{")
+		cb.IncrementIndent()
+		cb.AddLine("b, err := %s.Marshal(version)", expr)
+		cb.AddLine("if err != nil {")
+		cb.IncrementIndent()
+		cb.AddLine("return nil, fmt.Errorf(\"problem marshalling %s: %%w\", err)", capitalize(fieldName))
+		cb.DecrementIndent()
+		cb.AddLine("}")
+		cb.AddLine("%s = append(%s, b...)", bufVar, bufVar)
+		cb.DecrementIndent()
+		cb.AddLine("}")
+	}
+	return nil
+}
+
+// addMarshalTaggedFieldsSection mirrors addReadTaggedFieldsSection for Marshal: each tagged field (if its
+// taggedVersions allow it for this version) is encoded into its own payload, then all of them are written out as
+// a varint count followed by (tag, size, payload) triples, in ascending tag order. Since collectTaggedFields
+// already returns fields in ascending tag order and entries are appended in that same order, the result is sorted
+// without an explicit sort at runtime.
+func addMarshalTaggedFieldsSection(cb util.CodeBuffer, name string, fields []model.MessageField, flexible bool) error {
+	if !flexible {
+		return nil
+	}
+	tagged := collectTaggedFields(fields)
+
+	cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
+	cb.IncrementIndent()
+	cb.AddLine("var tagged []%s", taggedFieldEntryTypeName(name))
+	for _, f := range tagged {
+		versions := addVersionIfClause(cb, f.TaggedVersions)
+		if versions {
+			cb.IncrementIndent()
+		}
+		cb.AddLine("{")
+		cb.IncrementIndent()
+		cb.AddLine("var tagBuf []byte")
+		err := addMarshalFieldBody(cb, name, f, flexible, "tagBuf")
+		if err != nil {
+			return err
+		}
+		cb.AddLine("tagged = append(tagged, %s{tag: %d, payload: tagBuf})", taggedFieldEntryTypeName(name), *f.Tag)
+		cb.DecrementIndent()
+		cb.AddLine("}")
+		if versions {
+			cb.DecrementIndent()
+			cb.AddLine("}")
+		}
+	}
+	cb.AddLine("buf = binary.AppendUvarint(buf, uint64(len(tagged)))")
+	cb.AddLine("for _, t := range tagged {")
+	cb.IncrementIndent()
+	cb.AddLine("buf = binary.AppendUvarint(buf, t.tag)")
+	cb.AddLine("buf = binary.AppendUvarint(buf, uint64(len(t.payload)))")
+	cb.AddLine("buf = append(buf, t.payload...)")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+
 	return nil
 }
 
@@ -404,45 +1209,67 @@ func addAllocateCommonStruct(cb util.CodeBuffer, fieldType string) {
 	_, ft := deconstructFieldType(fieldType)
 	constructName := fmt.Sprintf("New%s", capitalize(ft))
 
-	cb.AddLine("v, err := %s(data)", constructName)
+	cb.AddLine("v, err := %s(data, version)", constructName)
 	cb.AddLine("if err != nil {")
 	cb.IncrementIndent()
 	cb.AddLine("return res, fmt.Errorf(\"problem building %s: %%w\", err)", capitalize(ft))
 	cb.DecrementIndent()
 	cb.AddLine("}")
+	cb.AddLine("data = data[v.Size(version):]")
 }
 
 func addAllocateInlineStruct(cb util.CodeBuffer, name string, fieldType string) {
 	_, ft := deconstructFieldType(fieldType)
 	constructName := fmt.Sprintf("New%s%s", name, capitalize(ft))
 
-	cb.AddLine("v, err := %s(data)", constructName)
+	cb.AddLine("v, err := %s(data, version)", constructName)
 	cb.AddLine("if err != nil {")
 	cb.IncrementIndent()
 	cb.AddLine("return res, fmt.Errorf(\"problem building %s%s: %%w\", err)", name, capitalize(ft))
 	cb.DecrementIndent()
 	cb.AddLine("}")
+	cb.AddLine("data = data[v.Size(version):]")
 }
 
 func addVersionIfClause(cb util.CodeBuffer, versions string) bool {
-	if m := versionRangeRegexp.FindStringSubmatch(versions); len(m) == 2 {
-		cb.AddLine("if version >= %d || version <= %d", m[0], m[1])
+	if m := versionRangeRegexp.FindStringSubmatch(versions); len(m) == 3 {
+		cb.AddLine("if version >= %s && version <= %s {", m[1], m[2])
 		return true
-	} else if m := versionMinRegexp.FindStringSubmatch(versions); len(m) == 1 {
-		cb.AddLine("if version >= %d", m[0])
+	} else if m := versionMinRegexp.FindStringSubmatch(versions); len(m) == 2 {
+		cb.AddLine("if version >= %s {", m[1])
 		return true
-	} else if m := versionRegexp.FindStringSubmatch(versions); len(m) == 1 {
-		cb.AddLine("if version == %d", m[0])
+	} else if m := versionRegexp.FindStringSubmatch(versions); len(m) == 2 {
+		cb.AddLine("if version == %s {", m[1])
 		return true
 	}
 	return false
 }
 
-func addReadString(cb util.CodeBuffer, fieldName string, appendToArray bool) {
+func addReadString(cb util.CodeBuffer, name string, fieldName string, appendToArray bool, flexible bool) {
 	cb.AddLine("{")
 	cb.IncrementIndent()
 
+	if flexible {
+		cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
+		cb.IncrementIndent()
+		addReadCompactString(cb, fieldName, appendToArray)
+		cb.DecrementIndent()
+		cb.AddLine("} else {")
+		cb.IncrementIndent()
+		addReadLegacyString(cb, fieldName, appendToArray)
+		cb.DecrementIndent()
+		cb.AddLine("}")
+	} else {
+		addReadLegacyString(cb, fieldName, appendToArray)
+	}
+
+	cb.DecrementIndent()
+	cb.AddLine("}")
+}
+
+func addReadLegacyString(cb util.CodeBuffer, fieldName string, appendToArray bool) {
 	cb.AddLine("stringLen := binary.BigEndian.Uint16(data)")
+	cb.AddLine("data = data[2:]")
 	cb.AddLine("if stringLen < 0 {")
 	cb.IncrementIndent()
 	cb.AddLine("return res, fmt.Errorf(\"non-nullable field %s was serialized as null\")", fieldName)
@@ -461,21 +1288,50 @@ func addReadString(cb util.CodeBuffer, fieldName string, appendToArray bool) {
 	cb.AddLine("data = data[stringLen:]")
 	cb.DecrementIndent()
 	cb.AddLine("}")
+}
 
+// addReadCompactString reads a KIP-482 compact string: an unsigned varint length of N+1, where 0 means null. The
+// generated types don't yet model field nullability (see model.MessageField), so a null compact string decodes to
+// the empty string rather than a distinguishable nil value.
+func addReadCompactString(cb util.CodeBuffer, fieldName string, appendToArray bool) {
+	addReadUvarint(cb, "strLen")
+	cb.AddLine("if strLen > 0 {")
+	cb.IncrementIndent()
+	cb.AddLine("strLen--")
+	if appendToArray {
+		cb.AddLine("res.%s = append(res.%s, string(data[0:strLen]))", capitalize(fieldName), capitalize(fieldName))
+	} else {
+		cb.AddLine("res.%s = string(data[0:strLen])", capitalize(fieldName))
+	}
+	cb.AddLine("data = data[strLen:]")
 	cb.DecrementIndent()
 	cb.AddLine("}")
 }
 
-func addReadBytes(cb util.CodeBuffer, fieldName string, appendToArray bool) {
+func addReadBytes(cb util.CodeBuffer, name string, fieldName string, appendToArray bool, flexible bool) {
 	cb.AddLine("{")
 	cb.IncrementIndent()
 
-	cb.AddLine("bytesLen, err := binary.ReadUvarint(bytes.NewReader(data))")
-	cb.AddLine("if err != nil {")
-	cb.IncrementIndent()
-	cb.AddLine("return res, fmt.Errorf(\"problem reading uvarint: %%w\", err)")
+	if flexible {
+		cb.AddLine("if %s(version) {", isFlexibleFuncName(name))
+		cb.IncrementIndent()
+		addReadCompactBytes(cb, fieldName, appendToArray)
+		cb.DecrementIndent()
+		cb.AddLine("} else {")
+		cb.IncrementIndent()
+		addReadLegacyBytes(cb, fieldName, appendToArray)
+		cb.DecrementIndent()
+		cb.AddLine("}")
+	} else {
+		addReadLegacyBytes(cb, fieldName, appendToArray)
+	}
+
 	cb.DecrementIndent()
 	cb.AddLine("}")
+}
+
+func addReadLegacyBytes(cb util.CodeBuffer, fieldName string, appendToArray bool) {
+	addReadUvarint(cb, "bytesLen")
 	cb.AddLine("if bytesLen < 0 {")
 	cb.IncrementIndent()
 	cb.AddLine("return res, fmt.Errorf(\"non-nullable field group was serialized as null\")")
@@ -488,7 +1344,21 @@ func addReadBytes(cb util.CodeBuffer, fieldName string, appendToArray bool) {
 		cb.AddLine("res.%s = data[0:bytesLen]", capitalize(fieldName))
 	}
 	cb.AddLine("data = data[bytesLen:]")
+}
 
+// addReadCompactBytes reads a KIP-482 compact byte array: an unsigned varint length of N+1, where 0 means null
+// (decoded here as empty, for the same reason addReadCompactString does).
+func addReadCompactBytes(cb util.CodeBuffer, fieldName string, appendToArray bool) {
+	addReadUvarint(cb, "bytesLen")
+	cb.AddLine("if bytesLen > 0 {")
+	cb.IncrementIndent()
+	cb.AddLine("bytesLen--")
+	if appendToArray {
+		cb.AddLine("res.%s = append(res.%s, data[0:bytesLen])", capitalize(fieldName), capitalize(fieldName))
+	} else {
+		cb.AddLine("res.%s = data[0:bytesLen]", capitalize(fieldName))
+	}
+	cb.AddLine("data = data[bytesLen:]")
 	cb.DecrementIndent()
 	cb.AddLine("}")
 }
@@ -0,0 +1,63 @@
+package generate
+
+import (
+	"fmt"
+
+	"github.com/leizor/go-kafka-message-generator/pkg/model"
+	"github.com/leizor/go-kafka-message-generator/pkg/util"
+)
+
+// GeneratedFile is one output unit a Plugin writes to: the code buffer for a single generated Go file, plus the
+// filename it will be written under. A Plugin that has nothing to emit for a given message leaves Name empty, and
+// Run skips writing that file.
+type GeneratedFile struct {
+	Name   string
+	Buffer util.CodeBuffer
+}
+
+// Plugin generates output from a parsed message spec, following the pattern GoVPP's binapigen exposes for its own
+// code generator plugins. Run invokes every registered Plugin once per input message, each against its own
+// GeneratedFile, so plugins don't need to coordinate buffers or filenames with one another. The built-in core
+// plugin emits the struct and Read/Size/Marshal codec; other plugins (e.g. rpc) can emit independent output from
+// the same in-memory model.Message without core knowing they exist.
+type Plugin interface {
+	Name() string
+	Generate(file *GeneratedFile, msg *model.Message) error
+}
+
+// additionalFilesPlugin is implemented by plugins that need to emit output once per run rather than once per
+// message, e.g. shared scaffolding that every per-message file the plugin produces depends on.
+type additionalFilesPlugin interface {
+	AdditionalFiles() []GeneratedFile
+}
+
+// pluginOptions bundles the per-run configuration threaded into every registered Plugin's constructor.
+type pluginOptions struct {
+	packageName string
+	version     string
+	singleFile  bool
+}
+
+// builtinPlugins are the Plugins known to the CLI's --plugin flag, keyed by name.
+var builtinPlugins = map[string]func(opts pluginOptions, specs []model.Message) Plugin{
+	"core": func(opts pluginOptions, _ []model.Message) Plugin {
+		return &corePlugin{packageName: opts.packageName, version: opts.version, singleFile: opts.singleFile}
+	},
+	"rpc": func(opts pluginOptions, specs []model.Message) Plugin {
+		return newRPCPlugin(opts.packageName, specs)
+	},
+}
+
+// resolvePlugins constructs the named Plugins, in that order, giving each opts and the full set of parsed specs so
+// a plugin like rpc can correlate messages across files.
+func resolvePlugins(names []string, opts pluginOptions, specs []model.Message) ([]Plugin, error) {
+	plugins := make([]Plugin, 0, len(names))
+	for _, name := range names {
+		newPlugin, ok := builtinPlugins[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown plugin: %s", name)
+		}
+		plugins = append(plugins, newPlugin(opts, specs))
+	}
+	return plugins, nil
+}
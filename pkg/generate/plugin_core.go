@@ -0,0 +1,97 @@
+package generate
+
+import (
+	"sort"
+
+	"github.com/leizor/go-kafka-message-generator/pkg/model"
+	"github.com/leizor/go-kafka-message-generator/pkg/util"
+)
+
+// corePlugin is the built-in Plugin that emits the struct and Read/Size/Marshal codec for a message. It is what
+// generate.Run produced before plugins existed, and is always available via --plugin=core.
+//
+// In singleFile mode, Generate doesn't write a file per message: it accumulates each message's body and its
+// imports, and AdditionalFiles flushes them as one combined file once every message has been seen.
+type corePlugin struct {
+	packageName string
+	version     string
+	singleFile  bool
+
+	mergedBody    util.CodeBuffer
+	mergedImports map[string]bool
+}
+
+func (p *corePlugin) Name() string {
+	return "core"
+}
+
+func (p *corePlugin) Generate(file *GeneratedFile, msg *model.Message) error {
+	if p.singleFile {
+		if p.mergedBody == nil {
+			p.mergedBody = util.NewCodeBuffer()
+			p.mergedImports = make(map[string]bool)
+		}
+		for _, dep := range collectImports(*msg) {
+			p.mergedImports[dep] = true
+		}
+		return generateFileBody(p.mergedBody, *msg)
+	}
+
+	filename, err := generateFile(p.packageName, *msg, file.Buffer, p.version)
+	if err != nil {
+		return err
+	}
+	file.Name = filename
+	return nil
+}
+
+// AdditionalFiles emits the output that's shared across every message rather than tied to one of them: the
+// GoKafkaMessageGeneratorPackageIsVersionN guard every per-message file asserts against via addAPIMetadata, and
+// uvarintSize, which every message's Size method calls but which is invariant across messages, so it's emitted
+// exactly once per package rather than once per message (where it would redeclare across files). In singleFile
+// mode this is the entire combined file (header, deduped imports, and every accumulated message body); otherwise
+// it's just version.go.
+func (p *corePlugin) AdditionalFiles() []GeneratedFile {
+	if p.singleFile {
+		cb := util.NewCodeBuffer()
+		cb.AddLine("// Code generated by kmg %s. DO NOT EDIT.", p.version)
+		cb.AddLine("package %s", p.packageName)
+
+		imports := make([]string, 0, len(p.mergedImports))
+		for dep := range p.mergedImports {
+			imports = append(imports, dep)
+		}
+		sort.Strings(imports)
+		cb.AddLine("import (")
+		cb.IncrementIndent()
+		for _, dep := range imports {
+			cb.AddLine("\"%s\"", dep)
+		}
+		cb.DecrementIndent()
+		cb.AddLine(")")
+
+		p.addVersionGuardDefinition(cb)
+		addUvarintSizeHelper(cb)
+
+		if p.mergedBody != nil {
+			p.mergedBody.WriteTo(cb)
+		}
+
+		return []GeneratedFile{{Name: "generated.go", Buffer: cb}}
+	}
+
+	cb := util.NewCodeBuffer()
+	cb.AddLine("// Code generated by kmg %s. DO NOT EDIT.", p.version)
+	cb.AddLine("package %s", p.packageName)
+	p.addVersionGuardDefinition(cb)
+	addUvarintSizeHelper(cb)
+
+	return []GeneratedFile{{Name: "version.go", Buffer: cb}}
+}
+
+func (p *corePlugin) addVersionGuardDefinition(cb util.CodeBuffer) {
+	cb.AddLine("// GoKafkaMessageGeneratorPackageIsVersion%d guards generated code against a newer, breaking", generatorAPIVersion)
+	cb.AddLine("// generator: every file this package emits asserts against it, so bumping this number is a signal")
+	cb.AddLine("// that previously generated files must be regenerated to compile again.")
+	cb.AddLine("const GoKafkaMessageGeneratorPackageIsVersion%d = true", generatorAPIVersion)
+}
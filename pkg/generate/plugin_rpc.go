@@ -0,0 +1,119 @@
+package generate
+
+import (
+	"strings"
+
+	"github.com/leizor/go-kafka-message-generator/pkg/model"
+	"github.com/leizor/go-kafka-message-generator/pkg/util"
+)
+
+// rpcPlugin emits a typed Client method for every request/response message pair it finds among the parsed specs,
+// matched by MessageType ("request"/"response") and a shared name prefix (e.g. "ProduceRequest"/"ProduceResponse"
+// share the prefix "Produce"). The method marshals the request, hands the bytes to the client's Dispatcher, and
+// decodes the reply with the matching generated reader. Framing the request with correlation ID/API key/version
+// headers is the Dispatcher implementation's job, not the generated method's: a Dispatcher sits closest to the wire
+// and already has to own correlation-ID bookkeeping to match a reply to the request that caused it, so duplicating
+// that state here would just be a second place for it to go stale. The dispatcher is addressed by the request's name
+// prefix; the message's numeric API key is not passed to it.
+type rpcPlugin struct {
+	packageName string
+	responses   map[string]model.Message // name prefix -> response message
+}
+
+func newRPCPlugin(packageName string, specs []model.Message) Plugin {
+	responses := make(map[string]model.Message)
+	for _, spec := range specs {
+		if spec.MessageType == "response" {
+			responses[strings.TrimSuffix(spec.Name, "Response")] = spec
+		}
+	}
+	return &rpcPlugin{packageName: packageName, responses: responses}
+}
+
+func (p *rpcPlugin) Name() string {
+	return "rpc"
+}
+
+func (p *rpcPlugin) Generate(file *GeneratedFile, msg *model.Message) error {
+	if msg.MessageType != "request" {
+		return nil
+	}
+	prefix := strings.TrimSuffix(msg.Name, "Request")
+	resp, ok := p.responses[prefix]
+	if !ok {
+		return nil
+	}
+
+	cb := file.Buffer
+	cb.AddLine("package %s", p.packageName)
+
+	cb.AddLine("import (")
+	cb.IncrementIndent()
+	cb.AddLine("\"context\"")
+	cb.AddLine("\"fmt\"")
+	cb.DecrementIndent()
+	cb.AddLine(")")
+
+	cb.AddLine("func (c *Client) Do%s(ctx context.Context, req *%s) (*%s, error) {", prefix, msg.Name, resp.Name)
+	cb.IncrementIndent()
+	cb.AddLine("reqBytes, err := req.Marshal(c.Version)")
+	cb.AddLine("if err != nil {")
+	cb.IncrementIndent()
+	cb.AddLine("return nil, fmt.Errorf(\"problem marshalling %s: %%w\", err)", msg.Name)
+	cb.DecrementIndent()
+	cb.AddLine("}")
+	cb.AddLine("respBytes, err := c.dispatch(ctx, %q, c.Version, reqBytes)", prefix)
+	cb.AddLine("if err != nil {")
+	cb.IncrementIndent()
+	cb.AddLine("return nil, fmt.Errorf(\"problem dispatching %s: %%w\", err)", msg.Name)
+	cb.DecrementIndent()
+	cb.AddLine("}")
+	cb.AddLine("res, err := Read%s(respBytes, c.Version)", resp.Name)
+	cb.AddLine("if err != nil {")
+	cb.IncrementIndent()
+	cb.AddLine("return nil, fmt.Errorf(\"problem reading %s: %%w\", err)", resp.Name)
+	cb.DecrementIndent()
+	cb.AddLine("}")
+	cb.AddLine("return &res, nil")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+
+	file.Name = prefix + "Client.go"
+	return nil
+}
+
+// AdditionalFiles emits client.go once per run: the Client and Dispatcher types every generated DoXxx method above
+// depends on, regardless of how many request/response pairs were found.
+func (p *rpcPlugin) AdditionalFiles() []GeneratedFile {
+	cb := util.NewCodeBuffer()
+	cb.AddLine("package %s", p.packageName)
+
+	cb.AddLine("import \"context\"")
+
+	cb.AddLine("// Dispatcher sends a framed request to the broker and returns the framed response payload. Framing -")
+	cb.AddLine("// the correlation ID, API key, and version header that precede req on the wire - is this interface's")
+	cb.AddLine("// job, not the generated DoXxx methods' above; a Dispatcher already has to own correlation-ID")
+	cb.AddLine("// bookkeeping to match a reply to the request that caused it, so that's where the rest of the header")
+	cb.AddLine("// belongs too.")
+	cb.AddLine("type Dispatcher interface {")
+	cb.IncrementIndent()
+	cb.AddLine("Dispatch(ctx context.Context, apiName string, version int, req []byte) ([]byte, error)")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+
+	cb.AddLine("// Client issues typed Kafka RPCs over a Dispatcher.")
+	cb.AddLine("type Client struct {")
+	cb.IncrementIndent()
+	cb.AddLine("Dispatcher Dispatcher")
+	cb.AddLine("Version    int")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+
+	cb.AddLine("func (c *Client) dispatch(ctx context.Context, apiName string, version int, req []byte) ([]byte, error) {")
+	cb.IncrementIndent()
+	cb.AddLine("return c.Dispatcher.Dispatch(ctx, apiName, version, req)")
+	cb.DecrementIndent()
+	cb.AddLine("}")
+
+	return []GeneratedFile{{Name: "client.go", Buffer: cb}}
+}
@@ -7,4 +7,8 @@ type Message struct {
 	FlexibleVersions string         `json:"flexibleVersions"`
 	Fields           []MessageField `json:"fields"`
 	CommonStructs    []CommonStruct `json:"commonStructs"`
+
+	// ApiKey identifies which Kafka API this message belongs to. It's absent for messages that aren't dispatched by
+	// API key, e.g. header types.
+	ApiKey *int `json:"apiKey,omitempty"`
 }
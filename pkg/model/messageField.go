@@ -8,4 +8,16 @@ type MessageField struct {
 	Default   *any           `json:"default,omitempty"`
 	Ignorable *bool          `json:"ignorable,omitempty"`
 	Fields    []MessageField `json:"fields"`
+
+	// Tag and TaggedVersions identify a KIP-482 tagged field: a field that is only ever carried in the tagged-field
+	// section of a flexible version, rather than inline with the rest of the struct. TaggedVersions uses the same
+	// syntax as Versions (e.g. "3+") and is evaluated against the versions in which the tag is actually written.
+	Tag            *int   `json:"tag,omitempty"`
+	TaggedVersions string `json:"taggedVersions,omitempty"`
+
+	// NullableVersions identifies the versions in which this field may be serialized as null, using the same
+	// syntax as Versions. Only types with a reserved null encoding (string, bytes, array, and struct fields, via
+	// their own length/compact-length sentinel) can be nullable; a primitive numeric or uuid field has no such
+	// sentinel and can't be.
+	NullableVersions string `json:"nullableVersions,omitempty"`
 }
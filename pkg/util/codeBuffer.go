@@ -10,6 +10,7 @@ type CodeBuffer interface {
 	IncrementIndent()
 	DecrementIndent()
 	AddLine(format string, a ...any)
+	AppendRaw(lines []string)
 	WriteTo(other CodeBuffer)
 	Write(w *bufio.Writer) error
 }
@@ -40,10 +41,15 @@ func (cb *codeBuffer) AddLine(format string, a ...any) {
 	cb.lines = append(cb.lines, line)
 }
 
+// AppendRaw appends lines that have already been rendered (indented, with any format verbs resolved), bypassing
+// AddLine's Sprintf pass. Without this, a line containing a literal "%w" or "%d" baked in by an earlier AddLine
+// call would be reinterpreted as a format string and corrupted.
+func (cb *codeBuffer) AppendRaw(lines []string) {
+	cb.lines = append(cb.lines, lines...)
+}
+
 func (cb *codeBuffer) WriteTo(other CodeBuffer) {
-	for _, line := range cb.lines {
-		other.AddLine(line)
-	}
+	other.AppendRaw(cb.lines)
 }
 
 func (cb *codeBuffer) Write(w *bufio.Writer) error {
@@ -0,0 +1,169 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/leizor/go-kafka-message-generator/pkg/model"
+)
+
+var (
+	arrayRegex         = regexp.MustCompile(`^(\[])?(.+)$`)
+	versionRangeRegexp = regexp.MustCompile(`^(\d+)-(\d+)$`)
+	versionMinRegexp   = regexp.MustCompile(`^(\d+)\+$`)
+	versionRegexp      = regexp.MustCompile(`^(\d+)$`)
+)
+
+// builtinTypes are the field types generate knows how to read/size/marshal without a CommonStruct or inline struct
+// backing them.
+var builtinTypes = map[string]bool{
+	"int8": true, "int16": true, "int32": true, "int64": true,
+	"string": true, "bytes": true, "uuid": true,
+}
+
+// nonNullableTypes are the builtin types with no reserved null encoding: a fixed-width primitive has every bit
+// pattern available to a real value, so there's no sentinel left to mean "null".
+var nonNullableTypes = map[string]bool{
+	"int8": true, "int16": true, "int32": true, "int64": true, "uuid": true,
+}
+
+// Validate checks a parsed message spec for the kinds of mistakes that would otherwise only surface as silently
+// broken generated code: malformed version ranges, field types that don't resolve to a built-in or a known
+// CommonStruct/inline struct, Default values that don't type-check against their field's Type, and field names
+// that collide within the same struct. It reports every problem it finds, rather than stopping at the first one.
+func Validate(m model.Message) error {
+	var errs []error
+
+	known := collectKnownTypes(m)
+
+	errs = append(errs, validateVersions(m.Name+".validVersions", m.ValidVersions)...)
+	if m.FlexibleVersions != "" {
+		errs = append(errs, validateVersions(m.Name+".flexibleVersions", m.FlexibleVersions)...)
+	}
+
+	errs = append(errs, validateFields(m.Name, m.Fields, known)...)
+	for _, cs := range m.CommonStructs {
+		errs = append(errs, validateVersions(cs.Name+".versions", cs.Versions)...)
+		errs = append(errs, validateFields(cs.Name, cs.Fields, known)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// collectKnownTypes gathers every type name a field's Type can legally resolve to: the built-ins, every
+// CommonStruct's name, and every inline struct's own type name (recursively, since inline structs can nest).
+func collectKnownTypes(m model.Message) map[string]bool {
+	known := make(map[string]bool, len(builtinTypes)+len(m.CommonStructs))
+	for t := range builtinTypes {
+		known[t] = true
+	}
+	for _, cs := range m.CommonStructs {
+		known[cs.Name] = true
+		collectInlineTypes(cs.Fields, known)
+	}
+	collectInlineTypes(m.Fields, known)
+	return known
+}
+
+func collectInlineTypes(fields []model.MessageField, known map[string]bool) {
+	for _, f := range fields {
+		if len(f.Fields) > 0 {
+			_, baseType := deconstructFieldType(f.Type)
+			known[baseType] = true
+			collectInlineTypes(f.Fields, known)
+		}
+	}
+}
+
+// validateFields checks every field in a single struct's field list, recursing into inline structs. context
+// identifies the enclosing struct (and, when called recursively, the enclosing field) for error messages.
+func validateFields(context string, fields []model.MessageField, known map[string]bool) []error {
+	var errs []error
+	seen := make(map[string]bool, len(fields))
+
+	for _, f := range fields {
+		fieldContext := fmt.Sprintf("%s.%s", context, f.Name)
+
+		if seen[f.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate field name %q", context, f.Name))
+		}
+		seen[f.Name] = true
+
+		errs = append(errs, validateVersions(fieldContext+".versions", f.Versions)...)
+		if f.Tag != nil {
+			errs = append(errs, validateVersions(fieldContext+".taggedVersions", f.TaggedVersions)...)
+		}
+
+		isArray, baseType := deconstructFieldType(f.Type)
+		if len(f.Fields) == 0 && !known[baseType] {
+			errs = append(errs, fmt.Errorf("%s: unknown type %q", fieldContext, f.Type))
+		}
+
+		if f.NullableVersions != "" {
+			errs = append(errs, validateVersions(fieldContext+".nullableVersions", f.NullableVersions)...)
+			if !isArray && nonNullableTypes[baseType] {
+				errs = append(errs, fmt.Errorf("%s: nullableVersions set on non-nullable type %q", fieldContext, f.Type))
+			}
+		}
+
+		if f.Default != nil {
+			if err := validateDefault(fieldContext, f.Type, isArray, *f.Default); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		if len(f.Fields) > 0 {
+			errs = append(errs, validateFields(fieldContext, f.Fields, known)...)
+		}
+	}
+
+	return errs
+}
+
+// validateDefault checks that a field's Default value type-checks against its declared Type. encoding/json decodes
+// JSON numbers into the field's `any` holder as float64, so that's what a numeric default is compared against,
+// rather than an integer Go type.
+func validateDefault(context string, fieldType string, isArray bool, value any) error {
+	if isArray {
+		return fmt.Errorf("%s: array field has a default value, which isn't supported", context)
+	}
+
+	_, baseType := deconstructFieldType(fieldType)
+	switch baseType {
+	case "string", "bytes":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: default value %v (%T) does not match type %s", context, value, value, fieldType)
+		}
+	case "int8", "int16", "int32", "int64":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: default value %v (%T) does not match type %s", context, value, value, fieldType)
+		}
+	default:
+		return fmt.Errorf("%s: type %s does not support a default value", context, fieldType)
+	}
+	return nil
+}
+
+// validateVersions checks that versions matches one of the three syntaxes generate understands ("N", "N+", or
+// "N-M"), and, for a range, that its bounds aren't inverted.
+func validateVersions(context string, versions string) []error {
+	if m := versionRangeRegexp.FindStringSubmatch(versions); m != nil {
+		min, _ := strconv.Atoi(m[1])
+		max, _ := strconv.Atoi(m[2])
+		if min > max {
+			return []error{fmt.Errorf("%s: version range %q has min > max", context, versions)}
+		}
+		return nil
+	}
+	if versionMinRegexp.MatchString(versions) || versionRegexp.MatchString(versions) {
+		return nil
+	}
+	return []error{fmt.Errorf("%s: %q is not a valid version string (want \"N\", \"N+\", or \"N-M\")", context, versions)}
+}
+
+func deconstructFieldType(fieldType string) (bool, string) {
+	m := arrayRegex.FindStringSubmatch(fieldType)
+	return m[1] == "[]", m[2]
+}
@@ -0,0 +1,136 @@
+package validate
+
+import (
+	"testing"
+
+	"github.com/leizor/go-kafka-message-generator/pkg/model"
+)
+
+func TestValidate(t *testing.T) {
+	intDefault := func(v any) *any { return &v }
+
+	cases := []struct {
+		name    string
+		message model.Message
+		wantErr bool
+	}{
+		{
+			name: "valid message",
+			message: model.Message{
+				Name:          "Foo",
+				ValidVersions: "0-1",
+				Fields: []model.MessageField{
+					{Name: "Bar", Type: "int32", Versions: "0+"},
+				},
+			},
+		},
+		{
+			name: "invalid validVersions",
+			message: model.Message{
+				Name:          "Foo",
+				ValidVersions: "not-a-version",
+				Fields: []model.MessageField{
+					{Name: "Bar", Type: "int32", Versions: "0+"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "inverted version range",
+			message: model.Message{
+				Name:          "Foo",
+				ValidVersions: "2-1",
+				Fields: []model.MessageField{
+					{Name: "Bar", Type: "int32", Versions: "0+"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate field name",
+			message: model.Message{
+				Name:          "Foo",
+				ValidVersions: "0",
+				Fields: []model.MessageField{
+					{Name: "Bar", Type: "int32", Versions: "0+"},
+					{Name: "Bar", Type: "int64", Versions: "0+"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown type",
+			message: model.Message{
+				Name:          "Foo",
+				ValidVersions: "0",
+				Fields: []model.MessageField{
+					{Name: "Bar", Type: "NotAType", Versions: "0+"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "array default value is unsupported",
+			message: model.Message{
+				Name:          "Foo",
+				ValidVersions: "0",
+				Fields: []model.MessageField{
+					{Name: "Bar", Type: "[]int32", Versions: "0+", Default: intDefault([]any{1})},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "default value type mismatch",
+			message: model.Message{
+				Name:          "Foo",
+				ValidVersions: "0",
+				Fields: []model.MessageField{
+					{Name: "Bar", Type: "int32", Versions: "0+", Default: intDefault("not a number")},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "nullableVersions on a string field is fine",
+			message: model.Message{
+				Name:          "Foo",
+				ValidVersions: "0",
+				Fields: []model.MessageField{
+					{Name: "Bar", Type: "string", Versions: "0+", NullableVersions: "0+"},
+				},
+			},
+		},
+		{
+			name: "nullableVersions on a primitive field is rejected",
+			message: model.Message{
+				Name:          "Foo",
+				ValidVersions: "0",
+				Fields: []model.MessageField{
+					{Name: "Bar", Type: "int32", Versions: "0+", NullableVersions: "0+"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "nullableVersions with invalid syntax",
+			message: model.Message{
+				Name:          "Foo",
+				ValidVersions: "0",
+				Fields: []model.MessageField{
+					{Name: "Bar", Type: "string", Versions: "0+", NullableVersions: "not-a-version"},
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := Validate(c.message)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}